@@ -0,0 +1,196 @@
+package codegen
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"go/format"
+	"go/types"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+
+	"golang.org/x/tools/go/analysis"
+)
+
+// A Cache is a directory-backed store of the output of previous
+// Generator.Run invocations, keyed by CacheKey. A driver that sets
+// Generator.Cache lets ToAnalyzer skip Run entirely when nothing that
+// would affect the generator's output for a package has changed.
+type Cache struct {
+	// Dir is the directory under which cache entries are stored, one
+	// file per key. It is created on first use if it does not exist.
+	Dir string
+}
+
+// A CacheEntry is everything a cached Run invocation produced: the
+// files written via Pass.Output, and the content of every file edited
+// via Pass.Edit or Pass.AddImport, keyed by the edited file's name on
+// disk. Caching both means a cache hit can replay a generator's edits
+// as faithfully as its ordinary output, without re-running Run.
+type CacheEntry struct {
+	Files map[string][]byte
+	Edits map[string][]byte
+}
+
+// Get returns the cached entry for key, if present.
+func (c *Cache) Get(key string) (CacheEntry, bool) {
+	data, err := ioutil.ReadFile(filepath.Join(c.Dir, key))
+	if err != nil {
+		return CacheEntry{}, false
+	}
+	var entry CacheEntry
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&entry); err != nil {
+		return CacheEntry{}, false
+	}
+	return entry, true
+}
+
+// Put stores entry under key, replacing any previous entry.
+func (c *Cache) Put(key string, entry CacheEntry) error {
+	if err := os.MkdirAll(c.Dir, 0o755); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(entry); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(c.Dir, key), buf.Bytes(), 0o644)
+}
+
+// CacheKey computes the content-addressed cache key for running
+// pass.Generator on pass: it combines the generator's name and
+// Version, its flag values, the source of pass.Files and
+// pass.OtherFiles, and the fingerprint of every package fact that pass.Generator's
+// Requires analyzers exported on pass.Pkg or one of its transitive
+// imports. Two passes that hash to the same key would make the
+// same Run produce the same output.
+func CacheKey(pass *Pass) string {
+	h := sha256.New()
+	g := pass.Generator
+
+	fmt.Fprintf(h, "generator %s@%s\n", g.Name, g.Version)
+
+	var flags []string
+	g.Flags.VisitAll(func(f *flag.Flag) {
+		flags = append(flags, f.Name+"="+f.Value.String())
+	})
+	sort.Strings(flags)
+	for _, f := range flags {
+		fmt.Fprintln(h, f)
+	}
+
+	for _, f := range pass.Files {
+		if err := format.Node(h, pass.Fset, f); err != nil {
+			// The file fails to print; fold the error itself into the
+			// key so a change in the failure still changes the key.
+			fmt.Fprintln(h, err)
+		}
+	}
+	for _, name := range pass.OtherFiles {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			fmt.Fprintln(h, err)
+			continue
+		}
+		h.Write(data)
+	}
+
+	for _, req := range g.Requires {
+		for _, fact := range req.FactTypes {
+			for _, pkg := range transitiveImports(pass.Pkg) {
+				v := reflect.New(reflect.TypeOf(fact).Elem()).Interface().(analysis.Fact)
+				if pass.ImportPackageFact(pkg, v) {
+					fmt.Fprintf(h, "fact %s %T %+v\n", pkg.Path(), v, v)
+				}
+			}
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// transitiveImports returns pkg and every package it imports, directly
+// or indirectly, each exactly once.
+func transitiveImports(pkg *types.Package) []*types.Package {
+	seen := map[*types.Package]bool{pkg: true}
+	pkgs := []*types.Package{pkg}
+	for i := 0; i < len(pkgs); i++ {
+		for _, imp := range pkgs[i].Imports() {
+			if !seen[imp] {
+				seen[imp] = true
+				pkgs = append(pkgs, imp)
+			}
+		}
+	}
+	return pkgs
+}
+
+// replay writes the cached files to output, as if Run had just
+// produced them.
+func replay(output Output, files map[string][]byte) error {
+	for name, data := range files {
+		w := output.OpenFile(name)
+		_, err := w.Write(data)
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordingOutput wraps an Output, recording every byte written to it
+// so that it can be stored in a Cache once Run completes. A generator
+// that writes through Pass.Print, Printf or Println never closes the
+// default file itself, so the recorded content is read lazily from
+// each writer's buffer by snapshot rather than collected on Close.
+type recordingOutput struct {
+	mu      sync.Mutex
+	real    Output
+	writers []*recordingWriter
+}
+
+func (r *recordingOutput) OpenFile(name string) io.WriteCloser {
+	w := &recordingWriter{name: name, w: r.real.OpenFile(name)}
+	r.mu.Lock()
+	r.writers = append(r.writers, w)
+	r.mu.Unlock()
+	return w
+}
+
+// snapshot returns the content written so far to every file opened
+// through r, keyed by name, whether or not it has been closed.
+func (r *recordingOutput) snapshot() map[string][]byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	files := make(map[string][]byte, len(r.writers))
+	for _, w := range r.writers {
+		files[w.name] = append(files[w.name], w.buf.Bytes()...)
+	}
+	return files
+}
+
+type recordingWriter struct {
+	name string
+	w    io.WriteCloser
+	buf  bytes.Buffer
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	return w.w.Write(p)
+}
+
+func (w *recordingWriter) Close() error {
+	return w.w.Close()
+}
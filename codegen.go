@@ -48,10 +48,31 @@ type Generator struct {
 	// Requires establishes a "horizontal" dependency between
 	// analysis passes (different analyzers, same package).
 	Requires []*analysis.Analyzer
+
+	// Version identifies the behavior of Run. It is part of a package's
+	// cache key (see Cache), so it should change whenever a change to
+	// the generator could change its output for some input.
+	Version string
+
+	// Cache, if non-nil, is consulted before Run is invoked for a
+	// package, and is populated with Run's output afterward, so that a
+	// later run with an unchanged cache key can skip Run entirely.
+	// A driver sets Cache on behalf of the user, typically from a
+	// "-cachedir" flag; it is nil, i.e. disabled, by default.
+	Cache *Cache
 }
 
 // ToAnalyzer converts the generator to an analyzer.
-func (g *Generator) ToAnalyzer(output io.Writer) *analysis.Analyzer {
+// output is called once per package to obtain the destination for any
+// file the generator opens via Pass.OpenFile; see SingleFile for a shim
+// that routes every package to a single io.Writer.
+//
+// edit, if non-nil, is called once per source file that the generator
+// edited via Pass.Edit or Pass.AddImport, after Run returns
+// successfully, with the file's name and its content after the edits
+// have been applied and formatted with go/format. A nil edit discards
+// any recorded edits; codegentest.Run, for example, passes nil.
+func (g *Generator) ToAnalyzer(output func(*types.Package) Output, edit func(pkg *types.Package, filename string, content []byte)) *analysis.Analyzer {
 	requires := make([]*analysis.Analyzer, len(g.Requires))
 	for i := range requires {
 		a := *g.Requires[i] // copy
@@ -66,6 +87,7 @@ func (g *Generator) ToAnalyzer(output io.Writer) *analysis.Analyzer {
 		Name: g.Name,
 		Doc:  g.Doc,
 		Run: func(pass *analysis.Pass) (interface{}, error) {
+			realOutput := output(pass.Pkg)
 			gpass := &Pass{
 				Generator:         g,
 				Fset:              pass.Fset,
@@ -75,17 +97,98 @@ func (g *Generator) ToAnalyzer(output io.Writer) *analysis.Analyzer {
 				TypesInfo:         pass.TypesInfo,
 				TypesSizes:        pass.TypesSizes,
 				ResultOf:          pass.ResultOf,
-				Output:            output,
+				Output:            realOutput,
 				ImportObjectFact:  pass.ImportObjectFact,
 				ImportPackageFact: pass.ImportPackageFact,
 			}
-			return nil, g.Run(gpass)
+
+			var cachedEdits map[string][]byte
+
+			run := func() error { return g.Run(gpass) }
+			if g.Cache != nil {
+				key := CacheKey(gpass)
+				if entry, ok := g.Cache.Get(key); ok {
+					cachedEdits = entry.Edits
+					run = func() error { return replay(realOutput, entry.Files) }
+				} else {
+					rec := &recordingOutput{real: realOutput}
+					gpass.Output = rec
+					run = func() error {
+						if err := g.Run(gpass); err != nil {
+							return err
+						}
+						edits, err := CollectEdits(pass.Fset, gpass.edits)
+						if err != nil {
+							return err
+						}
+						return g.Cache.Put(key, CacheEntry{Files: rec.snapshot(), Edits: edits})
+					}
+				}
+			}
+			if err := run(); err != nil {
+				return nil, err
+			}
+
+			if edit != nil {
+				if cachedEdits != nil {
+					for name, content := range cachedEdits {
+						edit(pass.Pkg, name, content)
+					}
+				} else {
+					for file, edits := range gpass.edits {
+						content, aerr := ApplyEdits(pass.Fset, file, edits)
+						if aerr != nil {
+							return nil, aerr
+						}
+						edit(pass.Pkg, pass.Fset.Position(file.Pos()).Filename, content)
+					}
+				}
+			}
+			return nil, nil
 		},
 		RunDespiteErrors: g.RunDespiteErrors,
 		Requires:         requires,
 	}
 }
 
+// Output is the destination to which a Generator writes its generated
+// files. OpenFile returns a writer for the named output file; the name
+// is defined by the generator (for example a relative file path) and is
+// opaque to codegen. A generator that writes only a single file per
+// package, in the style of gofmt, may pass "" as the name.
+//
+// The returned io.WriteCloser should be closed by the generator once it
+// is done writing; drivers that write to disk use Close to flush and
+// close the underlying file.
+type Output interface {
+	OpenFile(name string) io.WriteCloser
+}
+
+// SingleFile adapts w to the Output interface, ignoring the requested
+// file name and writing every file to w. It preserves the behavior of
+// generators written before Output was introduced, when Pass.Output was
+// a single io.Writer.
+func SingleFile(w io.Writer) Output {
+	return singleFile{w}
+}
+
+type singleFile struct {
+	io.Writer
+}
+
+func (s singleFile) OpenFile(string) io.WriteCloser {
+	return nopCloser{s.Writer}
+}
+
+// nopCloser adapts an io.Writer to io.WriteCloser with a no-op Close,
+// for writers, such as os.Stdout or a bytes.Buffer, that are not
+// otherwise closeable by the generator.
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
 // A Pass provides information to the Run function that applies a specific
 // generator to a single Go package.
 // The Run function should not call any of the Pass functions concurrently.
@@ -107,9 +210,14 @@ type Pass struct {
 	// analysis's ResultType.
 	ResultOf map[*analysis.Analyzer]interface{}
 
-	// Output is the destination of the generator.
-	// Pass's Print, Println, Printf outputs to this writer.
-	Output io.Writer
+	// Output is the destination of the generator's files.
+	// Use OpenFile to obtain a writer for a named file;
+	// Pass's Print, Println, Printf write to the default (unnamed) file.
+	Output Output
+
+	// defaultFile is the writer for the default (unnamed) file,
+	// opened lazily by Print, Println and Printf.
+	defaultFile io.WriteCloser
 
 	// ImportObjectFact retrieves a fact associated with obj.
 	// Given a value ptr of type *T, where *T satisfies Fact,
@@ -123,19 +231,39 @@ type Pass struct {
 	// which must be this package or one of its dependencies.
 	// See comments for ImportObjectFact.
 	ImportPackageFact func(pkg *types.Package, fact analysis.Fact) bool
+
+	// edits accumulates the edits recorded by Edit, keyed by the file
+	// they apply to. See Edits.
+	edits map[*ast.File][]TextEdit
+}
+
+// OpenFile returns a writer for the named output file, obtained from
+// pass.Output. A generator that writes only a single file per package
+// may pass "" as name.
+func (pass *Pass) OpenFile(name string) io.WriteCloser {
+	return pass.Output.OpenFile(name)
+}
+
+// file returns the writer for the default (unnamed) file, opening it
+// via OpenFile on first use.
+func (pass *Pass) file() io.Writer {
+	if pass.defaultFile == nil {
+		pass.defaultFile = pass.OpenFile("")
+	}
+	return pass.defaultFile
 }
 
-// Print is a wrapper of fmt.Fprint with pass.Output.
+// Print is a wrapper of fmt.Fprint with the default file of pass.Output.
 func (pass *Pass) Print(a ...interface{}) (n int, err error) {
-	return fmt.Fprint(pass.Output, a...)
+	return fmt.Fprint(pass.file(), a...)
 }
 
-// Printf is a wrapper of fmt.Fprintf with pass.Output.
+// Printf is a wrapper of fmt.Fprintf with the default file of pass.Output.
 func (pass *Pass) Printf(format string, a ...interface{}) (n int, err error) {
-	return fmt.Fprintf(pass.Output, format, a...)
+	return fmt.Fprintf(pass.file(), format, a...)
 }
 
-// Println is a wrapper of fmt.Fprintln with pass.Output.
+// Println is a wrapper of fmt.Fprintln with the default file of pass.Output.
 func (pass *Pass) Println(a ...interface{}) (n int, err error) {
-	return fmt.Fprintln(pass.Output, a...)
+	return fmt.Fprintln(pass.file(), a...)
 }
@@ -9,13 +9,15 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
-	"strings"
+	"sort"
+	"sync"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/gostaticanalysis/codegen"
 	"golang.org/x/tools/go/analysis"
 	"golang.org/x/tools/go/analysis/analysistest"
+	"golang.org/x/tools/txtar"
 )
 
 var TestData = analysistest.TestData
@@ -24,11 +26,21 @@ type Testing = analysistest.Testing
 
 // A Result holds the result of applying a generator to a package.
 type Result struct {
-	Dir    string
-	Pass   *codegen.Pass
-	Facts  map[types.Object][]analysis.Fact
-	Err    error
-	Output *bytes.Buffer
+	Dir   string
+	Pass  *codegen.Pass
+	Facts map[types.Object][]analysis.Fact
+	Err   error
+
+	// Files holds the generated output, keyed by the name passed to
+	// Pass.OpenFile. A generator that writes a single unnamed file, in
+	// the style of gofmt, stores it under the key "".
+	Files map[string]*bytes.Buffer
+
+	// Edited holds the content of every source file the generator
+	// edited via Pass.Edit or Pass.AddImport, keyed by the file's base
+	// name, after the edits have been applied. It is populated only by
+	// RunWithEdits.
+	Edited map[string][]byte
 }
 
 // Run applies a generator to the packages denoted by the "go list" patterns.
@@ -37,21 +49,70 @@ type Result struct {
 // directory using golang.org/x/tools/go/packages, runs the generator on
 // them.
 func Run(t Testing, dir string, g *codegen.Generator, patterns ...string) []*Result {
-	outputs := map[*types.Package]*bytes.Buffer{}
-	outputFunc := g.Output
-	_g := *g
-	g = &_g
-	g.Output = func(pkg *types.Package) io.Writer {
-		var buf bytes.Buffer
-		if outputFunc == nil {
-			outputs[pkg] = &buf
-			return &buf
+	var mu sync.Mutex
+	outputs := map[*types.Package]*memFS{}
+
+	a := g.ToAnalyzer(func(pkg *types.Package) codegen.Output {
+		mu.Lock()
+		defer mu.Unlock()
+		fs := newMemFS()
+		outputs[pkg] = fs
+		return fs
+	}, nil)
+
+	rs := analysistest.Run(t, dir, a, patterns...)
+	results := make([]*Result, len(rs))
+	for i := range rs {
+		gpass := &codegen.Pass{
+			Generator:         g,
+			Fset:              rs[i].Pass.Fset,
+			Files:             rs[i].Pass.Files,
+			OtherFiles:        rs[i].Pass.OtherFiles,
+			Pkg:               rs[i].Pass.Pkg,
+			TypesInfo:         rs[i].Pass.TypesInfo,
+			TypesSizes:        rs[i].Pass.TypesSizes,
+			ResultOf:          rs[i].Pass.ResultOf,
+			ImportObjectFact:  rs[i].Pass.ImportObjectFact,
+			ImportPackageFact: rs[i].Pass.ImportPackageFact,
+		}
+		results[i] = &Result{
+			Dir:   filepath.Join(dir, "src", filepath.ToSlash(rs[i].Pass.Pkg.Path())),
+			Pass:  gpass,
+			Facts: rs[i].Facts,
+			Err:   rs[i].Err,
+			Files: outputs[rs[i].Pass.Pkg].files,
 		}
-		w := outputFunc(pkg)
-		return io.MultiWriter(w, &buf)
 	}
 
-	a := g.ToAnalyzer()
+	return results
+}
+
+// RunWithEdits behaves like Run, but additionally applies any edits the
+// generator recorded via Pass.Edit or Pass.AddImport to the original
+// source files, and makes the edited content available via the
+// returned Results' Edited field, keyed by the edited file's base name
+// (for example "a.go"), so that Golden can verify it alongside the
+// generator's ordinary output.
+func RunWithEdits(t Testing, dir string, g *codegen.Generator, patterns ...string) []*Result {
+	var mu sync.Mutex
+	outputs := map[*types.Package]*memFS{}
+	edited := map[*types.Package]map[string][]byte{}
+
+	a := g.ToAnalyzer(func(pkg *types.Package) codegen.Output {
+		mu.Lock()
+		defer mu.Unlock()
+		fs := newMemFS()
+		outputs[pkg] = fs
+		return fs
+	}, func(pkg *types.Package, filename string, content []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		if edited[pkg] == nil {
+			edited[pkg] = map[string][]byte{}
+		}
+		edited[pkg][filepath.Base(filename)] = content
+	})
+
 	rs := analysistest.Run(t, dir, a, patterns...)
 	results := make([]*Result, len(rs))
 	for i := range rs {
@@ -64,7 +125,6 @@ func Run(t Testing, dir string, g *codegen.Generator, patterns ...string) []*Res
 			TypesInfo:         rs[i].Pass.TypesInfo,
 			TypesSizes:        rs[i].Pass.TypesSizes,
 			ResultOf:          rs[i].Pass.ResultOf,
-			Output:            outputs[rs[i].Pass.Pkg],
 			ImportObjectFact:  rs[i].Pass.ImportObjectFact,
 			ImportPackageFact: rs[i].Pass.ImportPackageFact,
 		}
@@ -73,16 +133,54 @@ func Run(t Testing, dir string, g *codegen.Generator, patterns ...string) []*Res
 			Pass:   gpass,
 			Facts:  rs[i].Facts,
 			Err:    rs[i].Err,
-			Output: outputs[rs[i].Pass.Pkg],
+			Files:  outputs[rs[i].Pass.Pkg].files,
+			Edited: edited[rs[i].Pass.Pkg],
 		}
 	}
 
 	return results
 }
 
+// memFS is a codegen.Output that records every file a generator opens,
+// keyed by name, so that Golden can compare them afterward.
+type memFS struct {
+	mu    sync.Mutex
+	files map[string]*bytes.Buffer
+}
+
+func newMemFS() *memFS {
+	return &memFS{files: map[string]*bytes.Buffer{}}
+}
+
+func (fs *memFS) OpenFile(name string) io.WriteCloser {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	buf, ok := fs.files[name]
+	if !ok {
+		buf = new(bytes.Buffer)
+		fs.files[name] = buf
+	}
+	return bufCloser{buf}
+}
+
+// bufCloser adapts a *bytes.Buffer to io.WriteCloser with a no-op Close.
+type bufCloser struct {
+	*bytes.Buffer
+}
+
+func (bufCloser) Close() error { return nil }
+
 // Golden compares the results with golden files.
-// Golden creates read a golden file which name is codegen.Generator.Name + ".golden".
-// The golden file is stored in same directory of the package.
+// Golden reads a golden file named codegen.Generator.Name + ".golden",
+// stored in the same directory as the package.
+//
+// A golden file may be a plain file, in which case it is compared
+// against the generator's default (unnamed) output file, or a txtar
+// archive (see golang.org/x/tools/txtar) containing one "-- name --"
+// section per file the generator produced. For a Result produced by
+// RunWithEdits, the files recorded in Edited are checked the same way,
+// under their base name (for example "a.go").
+//
 // If Golden cannot find a golden file or the result of Generator test is not same with the golden,
 // Golden reports error via *testing.T.
 // If update is true, golden files would be updated.
@@ -109,29 +207,85 @@ func Golden(t *testing.T, results []*Result, update bool) {
 func golden(t *testing.T, r *Result, update bool) {
 	t.Helper()
 
-	fname := fmt.Sprintf("%s.golden", r.Pass.Generator.Name)
+	gname := r.Pass.Generator.Name
+	fname := fmt.Sprintf("%s.golden", gname)
 	fpath := filepath.Join(r.Dir, fname)
 	gf, err := ioutil.ReadFile(fpath)
 	if err != nil {
 		t.Fatal("unexpected error:", err)
 	}
 
-	got := r.Output.String()
-	r.Output = bytes.NewBufferString(got)
-
-	if !update {
-		if diff := cmp.Diff(string(gf), got); diff != "" {
-			gname := r.Pass.Generator.Name
-			t.Errorf("%s's output is different from the golden file(%s):\n%s", gname, fpath, diff)
+	ar := txtar.Parse(gf)
+	want := make(map[string]string, len(ar.Files))
+	if len(ar.Files) == 0 {
+		// A plain golden file is the content of the default output file.
+		want[""] = string(ar.Comment)
+	} else {
+		for _, f := range ar.Files {
+			want[f.Name] = string(f.Data)
 		}
+	}
+
+	got := make(map[string]string, len(r.Files)+len(r.Edited))
+	for name, buf := range r.Files {
+		got[name] = buf.String()
+	}
+	for name, content := range r.Edited {
+		got[name] = string(content)
+	}
+
+	names := make([]string, 0, len(got))
+	for name := range got {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if update {
+		updateGolden(t, fpath, names, got)
 		return
 	}
 
+	for _, name := range names {
+		w, ok := want[name]
+		if !ok {
+			t.Errorf("%s's output contains a file not present in the golden file(%s): %q", gname, fpath, name)
+			continue
+		}
+		if diff := cmp.Diff(w, got[name]); diff != "" {
+			if name == "" {
+				t.Errorf("%s's output is different from the golden file(%s):\n%s", gname, fpath, diff)
+			} else {
+				t.Errorf("%s's output for %q is different from the golden file(%s):\n%s", gname, name, fpath, diff)
+			}
+		}
+	}
+
+	for name := range want {
+		if _, ok := got[name]; !ok {
+			t.Errorf("%s's golden file(%s) contains %q, which the generator did not produce", gname, fpath, name)
+		}
+	}
+}
+
+func updateGolden(t *testing.T, fpath string, names []string, files map[string]string) {
+	t.Helper()
+
+	var data []byte
+	if len(names) == 1 && names[0] == "" {
+		data = []byte(files[""])
+	} else {
+		ar := &txtar.Archive{}
+		for _, name := range names {
+			ar.Files = append(ar.Files, txtar.File{Name: name, Data: []byte(files[name])})
+		}
+		data = txtar.Format(ar)
+	}
+
 	newGolden, err := os.Create(fpath)
 	if err != nil {
 		t.Fatal("unexpected error:", err)
 	}
-	if _, err := io.Copy(newGolden, strings.NewReader(got)); err != nil {
+	if _, err := newGolden.Write(data); err != nil {
 		t.Fatal("unexpected error:", err)
 	}
 	if err := newGolden.Close(); err != nil {
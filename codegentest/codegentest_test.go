@@ -0,0 +1,130 @@
+package codegentest_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/gostaticanalysis/codegen"
+	"github.com/gostaticanalysis/codegen/codegentest"
+)
+
+// multiOutGenerator writes two named output files, to exercise the
+// multi-file txtar golden fixtures added for multi-file output.
+var multiOutGenerator = &codegen.Generator{
+	Name: "multiout",
+	Doc:  "writes two named output files",
+	Run: func(pass *codegen.Pass) error {
+		af := pass.OpenFile("a.txt")
+		fmt.Fprintf(af, "package %s: a\n", pass.Pkg.Name())
+		if err := af.Close(); err != nil {
+			return err
+		}
+		bf := pass.OpenFile("b.txt")
+		fmt.Fprintf(bf, "package %s: b\n", pass.Pkg.Name())
+		return bf.Close()
+	},
+}
+
+func TestMultiFileGolden(t *testing.T) {
+	rs := codegentest.Run(t, codegentest.TestData(), multiOutGenerator, "demo")
+	codegentest.Golden(t, rs, false)
+}
+
+// countRuns counts how many times countGenerator.Run actually executes,
+// so TestCache can tell a cache hit from a cache miss.
+var countRuns int
+
+var countGenerator = &codegen.Generator{
+	Name:    "countgen",
+	Doc:     "writes a constant file and counts how many times Run executes",
+	Version: "v1",
+	Run: func(pass *codegen.Pass) error {
+		countRuns++
+		_, err := fmt.Fprintln(pass.OpenFile(""), "count")
+		return err
+	},
+}
+
+func TestCache(t *testing.T) {
+	countRuns = 0
+	countGenerator.Cache = &codegen.Cache{Dir: t.TempDir()}
+	defer func() { countGenerator.Cache = nil }()
+
+	for i := 0; i < 2; i++ {
+		rs := codegentest.Run(t, codegentest.TestData(), countGenerator, "demo")
+		codegentest.Golden(t, rs, false)
+	}
+
+	if countRuns != 1 {
+		t.Errorf("Run executed %d times across two runs with a warm cache, want 1", countRuns)
+	}
+}
+
+// editGenerator adds an import of fmt to the package's first file, and
+// writes a constant output file, so TestEditCache can exercise the
+// interaction between the cache and recorded edits.
+var editGenerator = &codegen.Generator{
+	Name:    "editgen",
+	Doc:     "adds an import of fmt and writes a constant output file",
+	Version: "v1",
+	Run: func(pass *codegen.Pass) error {
+		pass.AddImport(pass.Files[0], "fmt", "")
+		_, err := fmt.Fprintln(pass.OpenFile("out.txt"), "edited")
+		return err
+	},
+}
+
+// staleGenerator's output never matches its checked-in golden file, to
+// exercise that Golden(t, rs, true) updates the golden file instead of
+// reporting the mismatch as a failure, as a normal (update=false) run
+// would.
+var staleGenerator = &codegen.Generator{
+	Name: "stalegen",
+	Doc:  "writes a constant file whose golden fixture is intentionally stale",
+	Run: func(pass *codegen.Pass) error {
+		_, err := fmt.Fprintln(pass.OpenFile(""), "fresh")
+		return err
+	},
+}
+
+func TestGoldenUpdate(t *testing.T) {
+	fpath := filepath.Join(codegentest.TestData(), "src", "demo", "stalegen.golden")
+	stale, err := ioutil.ReadFile(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ioutil.WriteFile(fpath, stale, 0o644)
+
+	ok := t.Run("update", func(t *testing.T) {
+		rs := codegentest.Run(t, codegentest.TestData(), staleGenerator, "demo")
+		codegentest.Golden(t, rs, true)
+	})
+	if !ok {
+		t.Error("Golden(t, rs, true) reported a failure despite the mismatch; -update should always succeed")
+	}
+
+	got, err := ioutil.ReadFile(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "fresh\n"; string(got) != want {
+		t.Errorf("golden file after update = %q, want %q", got, want)
+	}
+}
+
+func TestEditCache(t *testing.T) {
+	editGenerator.Cache = &codegen.Cache{Dir: t.TempDir()}
+	defer func() { editGenerator.Cache = nil }()
+
+	for i := 0; i < 2; i++ {
+		rs := codegentest.RunWithEdits(t, codegentest.TestData(), editGenerator, "demo")
+		codegentest.Golden(t, rs, false)
+		for _, r := range rs {
+			if len(r.Edited) == 0 {
+				t.Errorf("run %d: Edited is empty, want the edited demo.go", i)
+			}
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package codegen
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/token"
+	"io/ioutil"
+	"sort"
+	"strconv"
+)
+
+// A TextEdit describes the replacement of the text between Pos and End
+// with NewText. It mirrors analysis.TextEdit, but is applied directly
+// to a source file by Pass.Edit rather than offered to the user as a
+// SuggestedFix.
+type TextEdit struct {
+	Pos     token.Pos
+	End     token.Pos
+	NewText []byte
+}
+
+// Edit records edits to be applied to file once Run returns. Edit does
+// not modify file; it only adds the edits to those returned by Edits,
+// and it is up to the driver
+// to decide what to do with the recorded edits once Run succeeds.
+// singlegenerator.Main, for example, applies them in place when run
+// with -w, in the manner of gofmt -w, and otherwise prints a diff.
+//
+// The Run function should not call Edit concurrently, for the same
+// reason it should not call any other Pass method concurrently.
+func (pass *Pass) Edit(file *ast.File, edits []TextEdit) {
+	if pass.edits == nil {
+		pass.edits = make(map[*ast.File][]TextEdit)
+	}
+	pass.edits[file] = append(pass.edits[file], edits...)
+}
+
+// AddImport records an edit that adds an import of path to file under
+// the local name name, or under the imported package's own name if name
+// is "". It is a no-op if file already imports path.
+//
+// AddImport is a convenience wrapper around Edit: it inserts a single
+// "import" declaration after the package clause, rather than merging
+// path into an existing import block, so the result may need a tool
+// such as goimports to tidy the grouping.
+func (pass *Pass) AddImport(file *ast.File, path, name string) {
+	for _, imp := range file.Imports {
+		if p, err := strconv.Unquote(imp.Path.Value); err == nil && p == path {
+			return
+		}
+	}
+
+	spec := strconv.Quote(path)
+	if name != "" {
+		spec = name + " " + spec
+	}
+
+	pos := file.Name.End()
+	pass.Edit(file, []TextEdit{{
+		Pos:     pos,
+		End:     pos,
+		NewText: []byte(fmt.Sprintf("\n\nimport %s", spec)),
+	}})
+}
+
+// Edits returns the edits recorded so far via Edit and AddImport, keyed
+// by the file they apply to.
+func (pass *Pass) Edits() map[*ast.File][]TextEdit {
+	return pass.edits
+}
+
+// CollectEdits applies every recorded edit and returns the resulting
+// content of each edited file, keyed by the file's name as recorded in
+// fset. It is used to capture a generator's edits in a form that can be
+// stored in a Cache, or replayed, without holding on to the *ast.File
+// values themselves.
+func CollectEdits(fset *token.FileSet, edits map[*ast.File][]TextEdit) (map[string][]byte, error) {
+	if len(edits) == 0 {
+		return nil, nil
+	}
+	files := make(map[string][]byte, len(edits))
+	for file, fileEdits := range edits {
+		content, err := ApplyEdits(fset, file, fileEdits)
+		if err != nil {
+			return nil, err
+		}
+		files[fset.Position(file.Pos()).Filename] = content
+	}
+	return files, nil
+}
+
+// ApplyEdits reads the source file named by fset's record of file's
+// position, applies edits to it, and formats the result with
+// go/format, in the manner of gofmt. It is used by drivers that honor
+// Pass.Edit, to turn recorded edits into the file's new content.
+func ApplyEdits(fset *token.FileSet, file *ast.File, edits []TextEdit) ([]byte, error) {
+	name := fset.Position(file.Pos()).Filename
+	src, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", name, err)
+	}
+
+	sorted := append([]TextEdit(nil), edits...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Pos < sorted[j].Pos })
+
+	var buf bytes.Buffer
+	offset := 0
+	for _, e := range sorted {
+		start := fset.Position(e.Pos).Offset
+		end := fset.Position(e.End).Offset
+		if start < offset {
+			return nil, fmt.Errorf("%s: overlapping edits at offset %d", name, start)
+		}
+		buf.Write(src[offset:start])
+		buf.Write(e.NewText)
+		offset = end
+	}
+	buf.Write(src[offset:])
+
+	out, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("%s: formatting edited source: %w", name, err)
+	}
+	return out, nil
+}
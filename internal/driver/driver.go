@@ -0,0 +1,412 @@
+// Package driver implements the -json driver mode shared by codegen's
+// command drivers. Instead of writing a generator's output to stdout, it
+// runs the generator on each loaded package and reports the result -
+// the output files, any error, and (with the "t" debug flag) the
+// elapsed time - as a JSON-encoded Package per package. This gives
+// editor and CI integrations a stable, machine-readable contract. Files
+// that the generator edited in place, via codegen.Pass.Edit or
+// codegen.Pass.AddImport, are reported the same way as ordinary output
+// files, alongside a Diff against their current content on disk.
+//
+// The package also implements the -debug flag: "t" enables the timing
+// just described, "p" runs packages sequentially instead of in
+// parallel, and "s" round-trips every fact through gob encoding, which
+// helps catch facts that are not safely serializable.
+//
+// Unlike the full analysis driver used by singlechecker and
+// multichecker, this driver does not persist facts across packages: a
+// generator's Requires analyzers see only the facts exported while
+// analyzing the same package. This is sufficient for the common case of
+// a generator that requires a purely local analyzer such as
+// golang.org/x/tools/go/analysis/passes/inspect.
+package driver
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"go/types"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/gostaticanalysis/codegen"
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/packages"
+)
+
+// Debug holds the toggles accepted by a driver's -debug flag.
+type Debug struct {
+	Timing         bool // "t": report elapsed time per package
+	Sequential     bool // "p": run packages one at a time, not in parallel
+	RoundTripFacts bool // "s": round-trip facts through gob, to exercise serialization
+}
+
+// ParseDebug parses the value of a -debug flag. Each character of s
+// selects one toggle: "t" for Timing, "p" for Sequential and "s" for
+// RoundTripFacts.
+func ParseDebug(s string) (Debug, error) {
+	var d Debug
+	for _, c := range s {
+		switch c {
+		case 't':
+			d.Timing = true
+		case 'p':
+			d.Sequential = true
+		case 's':
+			d.RoundTripFacts = true
+		default:
+			return Debug{}, fmt.Errorf("unknown -debug flag %q", string(c))
+		}
+	}
+	return d, nil
+}
+
+// A File is one output file produced by a generator.
+type File struct {
+	Name    string `json:"name"`
+	Content string `json:"content,omitempty"`
+	Diff    string `json:"diff,omitempty"` // diff against the on-disk file of the same name, if any
+}
+
+// A Package is the JSON report of running a generator on a single package.
+type Package struct {
+	Generator string        `json:"generator"`
+	Package   string        `json:"package"`
+	Files     []File        `json:"files,omitempty"`
+	Err       string        `json:"err,omitempty"`
+	Elapsed   time.Duration `json:"elapsed,omitempty"`
+}
+
+// RunJSON runs g on the packages denoted by patterns and returns one
+// Package report per loaded package, in load order.
+func RunJSON(g *codegen.Generator, debug Debug, patterns []string) ([]*Package, error) {
+	cfg := &packages.Config{
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedSyntax |
+			packages.NeedTypes | packages.NeedTypesInfo | packages.NeedDeps | packages.NeedImports,
+	}
+	pkgs, err := packages.Load(cfg, patterns...)
+	if err != nil {
+		return nil, fmt.Errorf("loading packages: %w", err)
+	}
+
+	reports := make([]*Package, len(pkgs))
+	run := func(i int) { reports[i] = runOne(g, debug, pkgs[i]) }
+
+	if debug.Sequential {
+		for i := range pkgs {
+			run(i)
+		}
+	} else {
+		var wg sync.WaitGroup
+		wg.Add(len(pkgs))
+		for i := range pkgs {
+			i := i
+			go func() {
+				defer wg.Done()
+				run(i)
+			}()
+		}
+		wg.Wait()
+	}
+
+	return reports, nil
+}
+
+func runOne(g *codegen.Generator, debug Debug, pkg *packages.Package) *Package {
+	start := time.Now()
+	report := &Package{Generator: g.Name, Package: pkg.PkgPath}
+	defer func() {
+		if debug.Timing {
+			report.Elapsed = time.Since(start)
+		}
+	}()
+
+	if len(pkg.Errors) > 0 && !g.RunDespiteErrors {
+		report.Err = pkg.Errors[0].Error()
+		return report
+	}
+
+	facts := newFactStore(debug.RoundTripFacts)
+	resultOf, err := runRequires(g.Requires, pkg, facts)
+	if err != nil {
+		report.Err = err.Error()
+		return report
+	}
+
+	out := newMemFS()
+	gpass := &codegen.Pass{
+		Generator:         g,
+		Fset:              pkg.Fset,
+		Files:             pkg.Syntax,
+		OtherFiles:        pkg.OtherFiles,
+		Pkg:               pkg.Types,
+		TypesInfo:         pkg.TypesInfo,
+		TypesSizes:        pkg.TypesSizes,
+		ResultOf:          resultOf,
+		Output:            out,
+		ImportObjectFact:  facts.importObjectFact,
+		ImportPackageFact: facts.importPackageFact,
+	}
+
+	var cachedEdits map[string][]byte
+	if g.Cache == nil {
+		if err := g.Run(gpass); err != nil {
+			report.Err = err.Error()
+		}
+	} else {
+		key := codegen.CacheKey(gpass)
+		switch entry, ok := g.Cache.Get(key); {
+		case ok:
+			out.setAll(entry.Files)
+			cachedEdits = entry.Edits
+		default:
+			if err := g.Run(gpass); err != nil {
+				report.Err = err.Error()
+			} else if edits, err := codegen.CollectEdits(pkg.Fset, gpass.Edits()); err != nil {
+				report.Err = err.Error()
+			} else if err := g.Cache.Put(key, codegen.CacheEntry{Files: out.snapshot(), Edits: edits}); err != nil {
+				report.Err = err.Error()
+			}
+		}
+	}
+
+	names := out.names()
+	report.Files = make([]File, len(names))
+	for i, name := range names {
+		content := out.files[name].String()
+		report.Files[i] = File{
+			Name:    name,
+			Content: content,
+			Diff:    diffOnDisk(pkg, name, content),
+		}
+	}
+
+	if report.Err == "" {
+		if cachedEdits != nil {
+			for name, content := range cachedEdits {
+				report.Files = append(report.Files, File{
+					Name:    filepath.Base(name),
+					Content: string(content),
+					Diff:    diffAgainst(name, string(content)),
+				})
+			}
+		} else {
+			for file, edits := range gpass.Edits() {
+				content, err := codegen.ApplyEdits(pkg.Fset, file, edits)
+				if err != nil {
+					report.Err = err.Error()
+					break
+				}
+				name := pkg.Fset.Position(file.Pos()).Filename
+				report.Files = append(report.Files, File{
+					Name:    filepath.Base(name),
+					Content: string(content),
+					Diff:    diffAgainst(name, string(content)),
+				})
+			}
+		}
+	}
+
+	return report
+}
+
+// diffAgainst compares content to the file currently on disk at path,
+// returning "" if they match or the file cannot be read.
+func diffAgainst(path, content string) string {
+	existing, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return cmp.Diff(string(existing), content)
+}
+
+// runRequires runs reqs (and, transitively, their own Requires)
+// against pkg, and returns the ResultOf map for those top-level
+// analyzers, as Generator.Run expects to find in Pass.ResultOf.
+func runRequires(reqs []*analysis.Analyzer, pkg *packages.Package, facts *factStore) (map[*analysis.Analyzer]interface{}, error) {
+	memo := map[*analysis.Analyzer]interface{}{}
+
+	var run func(a *analysis.Analyzer) (interface{}, error)
+	run = func(a *analysis.Analyzer) (interface{}, error) {
+		if v, ok := memo[a]; ok {
+			return v, nil
+		}
+
+		resultOf := map[*analysis.Analyzer]interface{}{}
+		for _, req := range a.Requires {
+			v, err := run(req)
+			if err != nil {
+				return nil, err
+			}
+			resultOf[req] = v
+		}
+
+		pass := &analysis.Pass{
+			Analyzer:          a,
+			Fset:              pkg.Fset,
+			Files:             pkg.Syntax,
+			Pkg:               pkg.Types,
+			TypesInfo:         pkg.TypesInfo,
+			TypesSizes:        pkg.TypesSizes,
+			ResultOf:          resultOf,
+			Report:            func(analysis.Diagnostic) {},
+			ImportObjectFact:  facts.importObjectFact,
+			ImportPackageFact: facts.importPackageFact,
+			ExportObjectFact:  facts.exportObjectFact,
+			ExportPackageFact: facts.exportPackageFact,
+		}
+		v, err := a.Run(pass)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", a.Name, err)
+		}
+		memo[a] = v
+		return v, nil
+	}
+
+	resultOf := make(map[*analysis.Analyzer]interface{}, len(reqs))
+	for _, req := range reqs {
+		v, err := run(req)
+		if err != nil {
+			return nil, err
+		}
+		resultOf[req] = v
+	}
+	return resultOf, nil
+}
+
+func diffOnDisk(pkg *packages.Package, name, content string) string {
+	if len(pkg.GoFiles) == 0 || name == "" {
+		return ""
+	}
+	path := filepath.Join(filepath.Dir(pkg.GoFiles[0]), name)
+	existing, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return cmp.Diff(string(existing), content)
+}
+
+// memFS is a codegen.Output that records every file a generator opens,
+// keyed by name.
+type memFS struct {
+	mu    sync.Mutex
+	files map[string]*bytes.Buffer
+}
+
+func newMemFS() *memFS { return &memFS{files: map[string]*bytes.Buffer{}} }
+
+func (fs *memFS) OpenFile(name string) io.WriteCloser {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	buf, ok := fs.files[name]
+	if !ok {
+		buf = new(bytes.Buffer)
+		fs.files[name] = buf
+	}
+	return bufCloser{buf}
+}
+
+// setAll replaces the contents of fs with files, as if each had just
+// been written by a generator. It is used to replay a Cache hit.
+func (fs *memFS) setAll(files map[string][]byte) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for name, data := range files {
+		fs.files[name] = bytes.NewBuffer(data)
+	}
+}
+
+// snapshot returns a copy of the files currently held by fs, suitable
+// for storing in a Cache.
+func (fs *memFS) snapshot() map[string][]byte {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	files := make(map[string][]byte, len(fs.files))
+	for name, buf := range fs.files {
+		files[name] = append([]byte(nil), buf.Bytes()...)
+	}
+	return files
+}
+
+func (fs *memFS) names() []string {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	names := make([]string, 0, len(fs.files))
+	for name := range fs.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type bufCloser struct {
+	*bytes.Buffer
+}
+
+func (bufCloser) Close() error { return nil }
+
+// factKey identifies a fact: either an object fact (obj non-nil) or a
+// package fact (obj nil), combined with the fact's concrete type.
+type factKey struct {
+	obj types.Object
+	typ reflect.Type
+}
+
+// factStore is a same-package, in-memory implementation of the facts
+// API expected by analysis.Pass and codegen.Pass.
+type factStore struct {
+	roundTrip bool
+
+	mu    sync.Mutex
+	facts map[factKey]analysis.Fact
+}
+
+func newFactStore(roundTrip bool) *factStore {
+	return &factStore{roundTrip: roundTrip, facts: map[factKey]analysis.Fact{}}
+}
+
+func (s *factStore) export(obj types.Object, fact analysis.Fact) {
+	if s.roundTrip {
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(fact); err != nil {
+			panic(fmt.Sprintf("fact %T is not gob-encodable: %v", fact, err))
+		}
+		clone := reflect.New(reflect.TypeOf(fact).Elem()).Interface().(analysis.Fact)
+		if err := gob.NewDecoder(&buf).Decode(clone); err != nil {
+			panic(fmt.Sprintf("fact %T did not round-trip through gob: %v", fact, err))
+		}
+		fact = clone
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.facts[factKey{obj, reflect.TypeOf(fact)}] = fact
+}
+
+func (s *factStore) exportObjectFact(obj types.Object, fact analysis.Fact) { s.export(obj, fact) }
+func (s *factStore) exportPackageFact(fact analysis.Fact)                  { s.export(nil, fact) }
+
+func (s *factStore) importFact(obj types.Object, ptr analysis.Fact) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.facts[factKey{obj, reflect.TypeOf(ptr)}]
+	if !ok {
+		return false
+	}
+	reflect.ValueOf(ptr).Elem().Set(reflect.ValueOf(v).Elem())
+	return true
+}
+
+func (s *factStore) importObjectFact(obj types.Object, fact analysis.Fact) bool {
+	return s.importFact(obj, fact)
+}
+
+func (s *factStore) importPackageFact(pkg *types.Package, fact analysis.Fact) bool {
+	return s.importFact(nil, fact)
+}
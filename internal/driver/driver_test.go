@@ -0,0 +1,44 @@
+package driver
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gostaticanalysis/codegen"
+)
+
+var pGenerator = &codegen.Generator{
+	Name: "pgen",
+	Doc:  "writes a constant output file naming the package",
+	Run: func(pass *codegen.Pass) error {
+		_, err := pass.Println("package", pass.Pkg.Name())
+		return err
+	},
+}
+
+func TestRunJSON(t *testing.T) {
+	reports, err := RunJSON(pGenerator, Debug{Timing: true}, []string{"./testdata/src/p"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 1 {
+		t.Fatalf("got %d reports, want 1", len(reports))
+	}
+
+	r := reports[0]
+	if r.Err != "" {
+		t.Fatalf("report.Err = %q, want none", r.Err)
+	}
+	if r.Elapsed <= 0 {
+		t.Error("report.Elapsed is 0, want a positive duration with Debug.Timing set")
+	}
+	if len(r.Files) != 1 {
+		t.Fatalf("got %d files, want 1", len(r.Files))
+	}
+	if want := "package p\n"; r.Files[0].Content != want {
+		t.Errorf("file content = %q, want %q", r.Files[0].Content, want)
+	}
+	if !strings.HasSuffix(r.Package, "/p") {
+		t.Errorf("report.Package = %q, want it to name package p", r.Package)
+	}
+}
@@ -0,0 +1,131 @@
+// Package multigenerator defines the main function for a code generation
+// command that can run a collection of generators.
+//
+// For example, if example.org/mockgen and example.org/dimock are generator
+// packages, all that is needed to define a standalone tool that can run
+// either (or both) of them is a file, example.org/gendriver/main.go,
+// containing:
+//
+//      // The gendriver command runs a collection of code generators.
+// 	package main
+//
+// 	import (
+// 		"example.org/dimock"
+// 		"example.org/mockgen"
+// 		"github.com/gostaticanalysis/codegen/multigenerator"
+// 	)
+//
+// 	func main() { multigenerator.Main(mockgen.Generator, dimock.Generator) }
+//
+// Each generator's own flags are namespaced as "-name.flag=value" so that
+// two generators can define a flag of the same name (for example "-type")
+// without colliding. The special "-run=name1,name2" flag restricts which
+// of the registered generators actually run; by default every generator
+// passed to Main runs.
+//
+// Main does not support in-place file editing: a generator that calls
+// codegen.Pass.Edit or codegen.Pass.AddImport makes the command exit
+// with an error once its Run returns with edits recorded, rather than
+// silently discarding them. Run such a generator through singlegenerator instead, where "-w"
+// (or a printed diff) makes the edit visible.
+package multigenerator
+
+import (
+	"flag"
+	"fmt"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/gostaticanalysis/codegen"
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/analysis/multichecker"
+)
+
+// Main is the main function for a code generation command that runs a
+// collection of generators. It is a wrapper of multichecker.Main.
+// See golang.org/x/tools/go/analysis/multichecker.
+func Main(gs ...*codegen.Generator) {
+	byName := make(map[string]*codegen.Generator, len(gs))
+	flags := flag.NewFlagSet("multigenerator", flag.ExitOnError)
+	run := flags.String("run", "", "comma-separated list of generators to run (default: all)")
+
+	for _, g := range gs {
+		if _, dup := byName[g.Name]; dup {
+			fmt.Fprintln(os.Stderr, "multigenerator: duplicate generator name:", g.Name)
+			os.Exit(1)
+		}
+		byName[g.Name] = g
+		g.Flags.VisitAll(func(f *flag.Flag) {
+			flags.Var(f.Value, g.Name+"."+f.Name, f.Usage)
+		})
+	}
+
+	flags.Usage = func() { usage(flags, gs) }
+	flags.Parse(os.Args[1:])
+
+	selected := gs
+	if *run != "" {
+		names := strings.Split(*run, ",")
+		selected = make([]*codegen.Generator, 0, len(names))
+		for _, name := range names {
+			g, ok := byName[name]
+			if !ok {
+				fmt.Fprintln(os.Stderr, "multigenerator: unknown generator:", name)
+				os.Exit(1)
+			}
+			selected = append(selected, g)
+		}
+	}
+
+	as := make([]*analysis.Analyzer, len(selected))
+	for i, g := range selected {
+		g := g
+		as[i] = g.ToAnalyzer(
+			func(*types.Package) codegen.Output { return codegen.SingleFile(os.Stdout) },
+			func(_ *types.Package, filename string, _ []byte) {
+				fmt.Fprintf(os.Stderr, "multigenerator: %s: in-place editing of %s is not supported; run this generator through singlegenerator instead\n", g.Name, filename)
+				os.Exit(1)
+			},
+		)
+	}
+
+	if err := analysis.Validate(as); err != nil {
+		errMsg := strings.ReplaceAll(err.Error(), "analyzer", "generator")
+		fmt.Fprintln(os.Stderr, "multigenerator:", errMsg)
+		os.Exit(1)
+	}
+
+	progname := os.Args[0]
+	os.Args = make([]string, flags.NArg()+1)
+	os.Args[0] = progname
+	copy(os.Args[1:], flags.Args())
+	flag.CommandLine.SetOutput(ioutil.Discard)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	multichecker.Main(as...)
+}
+
+func usage(flags *flag.FlagSet, gs []*codegen.Generator) {
+	fmt.Fprintln(os.Stderr, "Usage: multigenerator [-flag] [package]")
+
+	fmt.Fprintln(os.Stderr, "\nRegistered generators:")
+	for _, g := range gs {
+		paras := strings.Split(g.Doc, "\n\n")
+		fmt.Fprintf(os.Stderr, "\n%s: %s\n", g.Name, paras[0])
+		if len(paras) > 1 {
+			fmt.Fprintln(os.Stderr, strings.Join(paras[1:], "\n\n"))
+		}
+		g.Flags.VisitAll(func(f *flag.Flag) {
+			fmt.Fprintf(os.Stderr, "  -%s.%s\n    \t%s\n", g.Name, f.Name, f.Usage)
+		})
+	}
+
+	fmt.Fprintln(os.Stderr, "\nFlags:")
+	flags.PrintDefaults()
+}
@@ -14,16 +14,46 @@
 //
 // 	func main() { singlegenerator.Main(mockgen.Generator) }
 //
+// The resulting command also accepts "-json", which reports the result
+// of each package as a JSON-encoded driver.Package instead of writing
+// the generated files to stdout, and "-debug", whose value is any
+// subset of the letters "tps" as described by driver.ParseDebug. Both
+// flags apply only to the -json mode.
+//
+// Finally, the command accepts "-cachedir" and "-nocache", which
+// control the incremental cache described by codegen.Cache: by
+// default, a package whose cache key (see codegen.CacheKey) matches a
+// previous run is not regenerated. "-nocache" disables the cache;
+// "-cachedir" overrides where it is stored, which otherwise defaults to
+// a "codegen" subdirectory of the build cache reported by "go env
+// GOCACHE".
+//
+// If the generator edits a source file via codegen.Pass.Edit or
+// codegen.Pass.AddImport, the command prints a diff of the edit to
+// stderr; "-w" makes it write the edited file in place instead, as
+// gofmt -w does.
+//
+// Since the generator's own flags share a namespace with "-json",
+// "-debug", "-cachedir", "-nocache" and "-w", a generator that defines
+// a flag of the same name makes Main exit with an error naming the
+// conflicting flag, rather than run with the wrong one silently
+// shadowed.
 package singlegenerator
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
+	"go/types"
 	"io/ioutil"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"strings"
 
+	"github.com/google/go-cmp/cmp"
 	"github.com/gostaticanalysis/codegen"
+	"github.com/gostaticanalysis/codegen/internal/driver"
 	"golang.org/x/tools/go/analysis"
 	"golang.org/x/tools/go/analysis/singlechecker"
 )
@@ -32,13 +62,59 @@ import (
 // It is a wrapper of singlechecker.Main.
 // See golang.org/x/tools/go/analysis/singlechecker.
 func Main(g *codegen.Generator) {
-	g.Flags.Parse(os.Args[1:])
-	os.Args = make([]string, g.Flags.NArg()+1)
+	flags := flag.NewFlagSet(g.Name, flag.ExitOnError)
+	jsonOutput := flags.Bool("json", false, "emit a JSON report per package instead of writing the generated files")
+	debugFlag := flags.String("debug", "", `debug flags, any subset of "tps" (t: timing, p: no parallelism, s: round-trip facts through gob)`)
+	cacheDir := flags.String("cachedir", "", `directory for the incremental cache (default: a "codegen" subdirectory of "go env GOCACHE")`)
+	noCache := flags.Bool("nocache", false, "disable the incremental cache")
+	write := flags.Bool("w", false, "write edited files in place instead of printing a diff (affects only generators that call Pass.Edit or Pass.AddImport)")
+	g.Flags.VisitAll(func(f *flag.Flag) {
+		if flags.Lookup(f.Name) != nil {
+			fmt.Fprintf(os.Stderr, "%s: generator flag -%s conflicts with a flag defined by singlegenerator; rename the generator's flag\n", g.Name, f.Name)
+			os.Exit(1)
+		}
+		flags.Var(f.Value, f.Name, f.Usage)
+	})
+
+	flags.Usage = func() {
+		paras := strings.Split(g.Doc, "\n\n")
+		fmt.Fprintf(os.Stderr, "%s: %s\n\n", g.Name, paras[0])
+		fmt.Fprintf(os.Stderr, "Usage: %s [-flag] [package]\n\n", g.Name)
+		if len(paras) > 1 {
+			fmt.Fprintln(os.Stderr, strings.Join(paras[1:], "\n\n"))
+		}
+		fmt.Fprintln(os.Stderr, "\nFlags:")
+		flags.PrintDefaults()
+	}
+	flags.Parse(os.Args[1:])
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	if !*noCache {
+		dir := *cacheDir
+		if dir == "" {
+			dir = defaultCacheDir()
+		}
+		g.Cache = &codegen.Cache{Dir: dir}
+	}
+
+	if *jsonOutput {
+		runJSON(g, *debugFlag, flags.Args())
+		return
+	}
+
+	os.Args = make([]string, flags.NArg()+1)
 	os.Args[0] = os.Args[0]
-	copy(os.Args[1:], g.Flags.Args())
+	copy(os.Args[1:], flags.Args())
 	flag.CommandLine.SetOutput(ioutil.Discard)
 
-	a := g.ToAnalyzer(os.Stdout)
+	a := g.ToAnalyzer(
+		func(*types.Package) codegen.Output { return codegen.SingleFile(os.Stdout) },
+		func(_ *types.Package, filename string, content []byte) { applyEdit(g, *write, filename, content) },
+	)
 	var requires []*analysis.Analyzer
 
 	requires, a.Requires = a.Requires, nil // Requires will be set after validation
@@ -53,21 +129,57 @@ func Main(g *codegen.Generator) {
 	}
 	a.Requires = requires
 
-	g.Flags.Usage = func() {
-		paras := strings.Split(g.Doc, "\n\n")
-		fmt.Fprintf(os.Stderr, "%s: %s\n\n", g.Name, paras[0])
-		fmt.Fprintf(os.Stderr, "Usage: %s [-flag] [package]\n\n", g.Name)
-		if len(paras) > 1 {
-			fmt.Fprintln(os.Stderr, strings.Join(paras[1:], "\n\n"))
+	singlechecker.Main(a)
+}
+
+// defaultCacheDir returns a "codegen" subdirectory of the build cache
+// reported by "go env GOCACHE", falling back to the system temp
+// directory if that command is unavailable.
+func defaultCacheDir() string {
+	out, err := exec.Command("go", "env", "GOCACHE").Output()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "codegen")
+	}
+	return filepath.Join(strings.TrimSpace(string(out)), "codegen")
+}
+
+// applyEdit either writes content to filename, if write is true, or
+// prints a diff between filename's current content and content to
+// stderr, in the manner of gofmt -w vs gofmt -d.
+func applyEdit(g *codegen.Generator, write bool, filename string, content []byte) {
+	if write {
+		if err := ioutil.WriteFile(filename, content, 0o644); err != nil {
+			fmt.Fprintln(os.Stderr, g.Name+":", err)
+			os.Exit(1)
 		}
-		fmt.Fprintln(os.Stderr, "\nFlags:")
-		g.Flags.PrintDefaults()
+		return
 	}
 
-	if g.Flags.NArg() == 0 {
-		g.Flags.Usage()
+	original, err := ioutil.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, g.Name+":", err)
 		os.Exit(1)
 	}
+	if diff := cmp.Diff(string(original), string(content)); diff != "" {
+		fmt.Fprintf(os.Stderr, "diff %s\n%s", filename, diff)
+	}
+}
 
-	singlechecker.Main(a)
+func runJSON(g *codegen.Generator, debugFlag string, patterns []string) {
+	debug, err := driver.ParseDebug(debugFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, g.Name+":", err)
+		os.Exit(1)
+	}
+
+	reports, err := driver.RunJSON(g, debug, patterns)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, g.Name+":", err)
+		os.Exit(1)
+	}
+
+	if err := json.NewEncoder(os.Stdout).Encode(reports); err != nil {
+		fmt.Fprintln(os.Stderr, g.Name+":", err)
+		os.Exit(1)
+	}
 }